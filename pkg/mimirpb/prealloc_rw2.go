@@ -4,16 +4,21 @@ package mimirpb
 
 import (
 	"fmt"
+	"math"
+	"strings"
 	"sync"
 
 	"github.com/prometheus/prometheus/util/zeropool"
 )
 
 const (
-	minPreallocatedTimeseriesRW2 = 100
-	maxPreallocatedTimeseriesRW2 = 10000
-	minPreallocatedLabelsRefs    = 20
-	maxPreallocatedLabelsRefs    = 200
+	minPreallocatedTimeseriesRW2      = 100
+	maxPreallocatedTimeseriesRW2      = 10000
+	minPreallocatedLabelsRefs         = 20
+	maxPreallocatedLabelsRefs         = 200
+	minPreallocatedExemplarsRW2       = 10
+	maxPreallocatedExemplarsRW2       = 1000
+	maxPreallocatedExemplarLabelsRefs = 40
 )
 
 var (
@@ -23,6 +28,12 @@ var (
 		},
 	}
 
+	preallocExemplarsRW2SlicePool = sync.Pool{
+		New: func() interface{} {
+			return make([]ExemplarRW2, 0, minPreallocatedExemplarsRW2)
+		},
+	}
+
 	// preallocLabelsRefsSlicePool pools a `*[]uint32` under the hood.
 	// Zeropool is a thin wrapper that encapsulates the extra pointer ref/deref for us.
 	// We can't just pool a []uint32 directly, as Go seems to try too hard to optimize around it and injects unexpected copies/allocations,
@@ -51,6 +62,43 @@ func reuseLabelsRefsSlice(s []uint32) {
 	preallocLabelsRefsSlicePool.Put(s[:0])
 }
 
+// reuseExemplarLabelsRefsSlice is like reuseLabelsRefsSlice, but caps at maxPreallocatedExemplarLabelsRefs:
+// exemplars carry far fewer labels than timeseries do, so there's no point holding on to a big one.
+func reuseExemplarLabelsRefsSlice(s []uint32) {
+	if cap(s) == 0 {
+		return
+	}
+
+	if cap(s) > maxPreallocatedExemplarLabelsRefs {
+		return
+	}
+
+	for i := range s {
+		s[i] = 0
+	}
+	preallocLabelsRefsSlicePool.Put(s[:0])
+}
+
+func exemplarsRW2SliceFromPool() []ExemplarRW2 {
+	return preallocExemplarsRW2SlicePool.Get().([]ExemplarRW2)
+}
+
+func reuseExemplarsRW2Slice(s []ExemplarRW2) {
+	if cap(s) == 0 {
+		return
+	}
+
+	if cap(s) > maxPreallocatedExemplarsRW2 {
+		return
+	}
+
+	for i := range s {
+		reuseExemplarLabelsRefsSlice(s[i].LabelsRefs)
+		s[i] = ExemplarRW2{}
+	}
+	preallocExemplarsRW2SlicePool.Put(s[:0])
+}
+
 func timeSeriesRW2SliceFromPool() []TimeSeriesRW2 {
 	return preallocTimeseriesRW2SlicePool.Get().([]TimeSeriesRW2)
 }
@@ -66,6 +114,7 @@ func reuseTimeSeriesRW2Slice(s []TimeSeriesRW2) {
 
 	for i := range s {
 		reuseLabelsRefsSlice(s[i].LabelsRefs)
+		reuseExemplarsRW2Slice(s[i].Exemplars)
 		s[i] = TimeSeriesRW2{}
 	}
 	preallocTimeseriesRW2SlicePool.Put(s[:0])
@@ -76,13 +125,51 @@ func ReuseRW2(req *WriteRequest) {
 	reuseTimeSeriesRW2Slice(req.TimeseriesRW2)
 }
 
+// HistogramEncoding controls how native histogram samples are represented when converting a RW1
+// WriteRequest to RW2.
+type HistogramEncoding int
+
+const (
+	// HistogramEncodingNative preserves each histogram's original representation, float or integer counts.
+	HistogramEncodingNative HistogramEncoding = iota
+	// HistogramEncodingForceInteger downcasts float histograms to integer-count histograms, rounding every
+	// count to the nearest uint64. This is lossy and intended only for downstream systems that can't ingest
+	// float histograms.
+	HistogramEncodingForceInteger
+)
+
+// ConvertOptions controls optional behaviour of FromWriteRequestToRW2Request.
+type ConvertOptions struct {
+	// SkipHistograms drops all histogram samples during conversion. Takes precedence over HistogramEncoding.
+	SkipHistograms bool
+	// HistogramEncoding controls how native histograms are represented in the converted request.
+	HistogramEncoding HistogramEncoding
+	// SynthesizeCreatedTimestampSamples makes the conversion synthesize a leading zero sample at a series'
+	// created timestamp when it is older than the series' first real sample, mirroring what Prometheus 2.49+
+	// does on scrape so that counters/histograms can be reset-detected across scrapes.
+	SynthesizeCreatedTimestampSamples bool
+	// OnInvalidCreatedTimestamp, if set, is called for each series whose created timestamp is newer than its
+	// own first sample/histogram - a malformed write that synthesis skips rather than guesses at. The
+	// argument is the series' metric name. This package has no metrics registry of its own, so callers that
+	// want visibility into how often this happens (e.g. via a prometheus.Counter.Inc()) should supply one.
+	OnInvalidCreatedTimestamp func(metricName string)
+}
+
 // FromWriteRequestToRW2Request converts a write request with RW1 fields populated to a write request with RW2 fields populated.
 // It makes a new RW2 request, leaving the original request alone - it is still up to the caller to free the provided request.
 // It might retain references in the RW1 request. It's not safe to free the RW1 request until the RW2 request is no longer used.
-func FromWriteRequestToRW2Request(rw1 *WriteRequest, commonSymbols *CommonSymbols, offset uint32) (*WriteRequest, error) {
+// opts is variadic for backwards compatibility with existing callers; at most one value is accepted.
+func FromWriteRequestToRW2Request(rw1 *WriteRequest, commonSymbols *CommonSymbols, offset uint32, opts ...ConvertOptions) (*WriteRequest, error) {
 	if rw1 == nil {
 		return nil, nil
 	}
+	if len(opts) > 1 {
+		return nil, fmt.Errorf("at most one ConvertOptions may be provided")
+	}
+	var o ConvertOptions
+	if len(opts) == 1 {
+		o = opts[0]
+	}
 	if len(rw1.SymbolsRW2) > 0 || len(rw1.TimeseriesRW2) > 0 {
 		return nil, fmt.Errorf("the provided request is already rw2")
 	}
@@ -98,6 +185,11 @@ func FromWriteRequestToRW2Request(rw1 *WriteRequest, commonSymbols *CommonSymbol
 	defer reuseSymbolsTable(symbols)
 	symbols.ConfigureCommonSymbols(offset, commonSymbols)
 
+	var metadataTypes map[string]MetricMetadata_MetricType
+	if o.SynthesizeCreatedTimestampSamples {
+		metadataTypes = metadataTypeByMetricName(rw1.Metadata)
+	}
+
 	// rw2Timeseries := make([]TimeSeriesRW2, 0, len(rw1.Timeseries)+len(rw1.Metadata)) // TODO: Pool-ify this allocation
 	expTimeseriesCount := len(rw1.Timeseries) + len(rw1.Metadata)
 	rw2Timeseries := timeSeriesRW2SliceFromPool()
@@ -117,10 +209,17 @@ func FromWriteRequestToRW2Request(rw1 *WriteRequest, commonSymbols *CommonSymbol
 			refs = append(refs, symbols.Symbolize(ts.Labels[i].Name), symbols.Symbolize(ts.Labels[i].Value))
 		}
 
+		samples := ts.Samples
+		histograms := convertHistogramsRW2(ts.Histograms, o)
+		if o.SynthesizeCreatedTimestampSamples {
+			name := metricName(ts.Labels)
+			samples, histograms = synthesizeCreatedTimestampSample(ts.CreatedTimestamp, metadataTypes[metricFamilyName(name)], samples, histograms, name, o.OnInvalidCreatedTimestamp)
+		}
+
 		rw2Timeseries = append(rw2Timeseries, TimeSeriesRW2{
 			LabelsRefs:       refs,
-			Samples:          ts.Samples,
-			Histograms:       ts.Histograms,
+			Samples:          samples,
+			Histograms:       histograms,
 			Exemplars:        FromExemplarsToExemplarsRW2(ts.Exemplars, symbols),
 			Metadata:         MetadataRW2{},
 			CreatedTimestamp: ts.CreatedTimestamp,
@@ -145,14 +244,26 @@ func FromWriteRequestToRW2Request(rw1 *WriteRequest, commonSymbols *CommonSymbol
 	return rw2, nil
 }
 
+// FromExemplarsToExemplarsRW2 converts RW1 exemplars to their RW2 representation. Value is carried over
+// verbatim, including for exemplars attached to native histogram samples, where it references a bucket
+// boundary rather than a plain sample value - RW2's ExemplarRW2.Value has the same meaning, so no extra
+// handling is needed there.
 func FromExemplarsToExemplarsRW2(exemplars []Exemplar, symbols StringSymbolizer) []ExemplarRW2 {
 	if exemplars == nil {
 		return nil
 	}
 
-	result := make([]ExemplarRW2, 0, len(exemplars)) // TODO: Pool-ify this allocation?
+	result := exemplarsRW2SliceFromPool()
+	if cap(result) < len(exemplars) {
+		result = make([]ExemplarRW2, 0, len(exemplars))
+	}
 	for _, ex := range exemplars {
-		refs := make([]uint32, 0, len(ex.Labels)*2)
+		const stringsPerLabel = 2
+		expLabelsCount := len(ex.Labels) * stringsPerLabel
+		refs := labelsRefsSliceFromPool()
+		if cap(refs) < expLabelsCount {
+			refs = make([]uint32, 0, expLabelsCount)
+		}
 		for i := range ex.Labels {
 			refs = append(refs, symbols.Symbolize(ex.Labels[i].Name), symbols.Symbolize(ex.Labels[i].Value))
 		}
@@ -167,6 +278,148 @@ func FromExemplarsToExemplarsRW2(exemplars []Exemplar, symbols StringSymbolizer)
 	return result
 }
 
+// convertHistogramsRW2 applies opts.SkipHistograms/opts.HistogramEncoding to hs, returning the slice of
+// histograms that should be carried over to the RW2 timeseries.
+func convertHistogramsRW2(hs []Histogram, opts ConvertOptions) []Histogram {
+	if opts.SkipHistograms || len(hs) == 0 {
+		return nil
+	}
+	if opts.HistogramEncoding != HistogramEncodingForceInteger {
+		return hs
+	}
+
+	result := make([]Histogram, len(hs))
+	for i, h := range hs {
+		if h.IsFloatHistogram() {
+			result[i] = floatHistogramToInt(h)
+		} else {
+			result[i] = h
+		}
+	}
+	return result
+}
+
+// floatHistogramToInt downcasts a float-count histogram to an integer-count histogram, rounding every
+// count to the nearest uint64. Bucket counts are re-encoded as deltas, matching the integer-histogram
+// convention, since float histograms store absolute per-bucket counts instead.
+func floatHistogramToInt(h Histogram) Histogram {
+	out := h
+	out.Count = &Histogram_CountInt{CountInt: uint64(math.Round(h.GetCountFloat()))}
+	out.ZeroCount = &Histogram_ZeroCountInt{ZeroCountInt: uint64(math.Round(h.GetZeroCountFloat()))}
+	out.PositiveDeltas = deltasFromCounts(h.PositiveCounts)
+	out.PositiveCounts = nil
+	out.NegativeDeltas = deltasFromCounts(h.NegativeCounts)
+	out.NegativeCounts = nil
+	return out
+}
+
+// deltasFromCounts converts a float histogram's absolute per-bucket counts into the successive deltas an
+// integer histogram stores them as.
+func deltasFromCounts(counts []float64) []int64 {
+	if len(counts) == 0 {
+		return nil
+	}
+
+	deltas := make([]int64, len(counts))
+	var prev int64
+	for i, c := range counts {
+		cur := int64(math.Round(c))
+		deltas[i] = cur - prev
+		prev = cur
+	}
+	return deltas
+}
+
+// metadataTypeByMetricName indexes metadata by metric family name so a series' type can be looked up by its
+// __name__ label during conversion.
+func metadataTypeByMetricName(metadata []*MetricMetadata) map[string]MetricMetadata_MetricType {
+	if len(metadata) == 0 {
+		return nil
+	}
+
+	result := make(map[string]MetricMetadata_MetricType, len(metadata))
+	for _, md := range metadata {
+		result[md.MetricFamilyName] = md.Type
+	}
+	return result
+}
+
+// metricName returns the value of the __name__ label, or "" if labels carries none.
+func metricName(labels []LabelAdapter) string {
+	for _, l := range labels {
+		if l.Name == "__name__" {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+// classicHistogramAndSummarySuffixes are the suffixes Prometheus appends to a classic histogram's or
+// summary's child series; metadata is only ever recorded under the bare metric family name.
+var classicHistogramAndSummarySuffixes = []string{"_bucket", "_sum", "_count"}
+
+// metricFamilyName strips a classic-histogram/summary child-series suffix (_bucket, _sum, _count) from
+// seriesName, so it can be matched against MetricMetadata.MetricFamilyName. Series names that don't carry
+// one of those suffixes are returned unchanged.
+func metricFamilyName(seriesName string) string {
+	for _, suffix := range classicHistogramAndSummarySuffixes {
+		if trimmed, ok := strings.CutSuffix(seriesName, suffix); ok && trimmed != "" {
+			return trimmed
+		}
+	}
+	return seriesName
+}
+
+// synthesizeCreatedTimestampSample prepends a zero-valued sample (or, for native histograms, an empty
+// histogram) at createdTimestamp when it is strictly older than the first real sample/histogram, so that
+// counters and histograms can be reset-detected across scrapes the way Prometheus 2.49+ does on scrape.
+// It's a no-op for metric types other than Counter/Histogram/Summary, when createdTimestamp is unset, and
+// when it matches the first sample already. When createdTimestamp is newer than the first sample, that
+// indicates an inconsistent series rather than something safe to guess at, so synthesis is skipped and
+// onInvalid, if non-nil, is called with metricName to surface the anomaly to the caller.
+func synthesizeCreatedTimestampSample(createdTimestamp int64, mtype MetricMetadata_MetricType, samples []Sample, histograms []Histogram, seriesName string, onInvalid func(metricName string)) ([]Sample, []Histogram) {
+	if createdTimestamp <= 0 {
+		return samples, histograms
+	}
+	switch mtype {
+	case MetricMetadata_COUNTER, MetricMetadata_HISTOGRAM, MetricMetadata_SUMMARY:
+	default:
+		return samples, histograms
+	}
+
+	switch {
+	case len(samples) > 0 && samples[0].TimestampMs > createdTimestamp:
+		samples = append([]Sample{{Value: 0, TimestampMs: createdTimestamp}}, samples...)
+	case len(histograms) > 0 && histograms[0].Timestamp > createdTimestamp:
+		histograms = append([]Histogram{emptyHistogramAt(createdTimestamp, histograms[0])}, histograms...)
+	case (len(samples) > 0 && samples[0].TimestampMs < createdTimestamp) || (len(histograms) > 0 && histograms[0].Timestamp < createdTimestamp):
+		if onInvalid != nil {
+			onInvalid(seriesName)
+		}
+	}
+	return samples, histograms
+}
+
+// emptyHistogramAt returns a zero-valued native histogram at timestamp ts, using ref's schema and zero
+// threshold so it's consistent with the samples that follow it. It matches ref's int/float encoding, since
+// a synthesized leading sample must be encoded the same way as the real samples around it.
+func emptyHistogramAt(ts int64, ref Histogram) Histogram {
+	h := Histogram{
+		Timestamp:     ts,
+		Schema:        ref.Schema,
+		ZeroThreshold: ref.ZeroThreshold,
+		ResetHint:     Histogram_UNKNOWN,
+	}
+	if ref.IsFloatHistogram() {
+		h.Count = &Histogram_CountFloat{}
+		h.ZeroCount = &Histogram_ZeroCountFloat{}
+	} else {
+		h.Count = &Histogram_CountInt{}
+		h.ZeroCount = &Histogram_ZeroCountInt{}
+	}
+	return h
+}
+
 func FromMetricMetadataToMetadataRW2(metadata *MetricMetadata, symbols StringSymbolizer) MetadataRW2 {
 	if metadata == nil {
 		return MetadataRW2{}
@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package mimirpb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func floatHistogramFixture() Histogram {
+	return Histogram{
+		Count:          &Histogram_CountFloat{CountFloat: 12},
+		ZeroCount:      &Histogram_ZeroCountFloat{ZeroCountFloat: 2},
+		Sum:            18.4,
+		Schema:         0,
+		ZeroThreshold:  0.001,
+		PositiveSpans:  []BucketSpan{{Offset: 0, Length: 2}},
+		PositiveCounts: []float64{1, 3},
+		NegativeSpans:  []BucketSpan{{Offset: 0, Length: 1}},
+		NegativeCounts: []float64{4},
+		ResetHint:      Histogram_UNKNOWN,
+		Timestamp:      1000,
+	}
+}
+
+func intHistogramFixture() Histogram {
+	return Histogram{
+		Count:          &Histogram_CountInt{CountInt: 12},
+		ZeroCount:      &Histogram_ZeroCountInt{ZeroCountInt: 2},
+		Sum:            18.4,
+		Schema:         0,
+		ZeroThreshold:  0.001,
+		PositiveSpans:  []BucketSpan{{Offset: 0, Length: 2}},
+		PositiveDeltas: []int64{1, 2},
+		ResetHint:      Histogram_UNKNOWN,
+		Timestamp:      1000,
+	}
+}
+
+func TestFromWriteRequestToRW2Request_Histograms(t *testing.T) {
+	t.Run("native histograms are preserved verbatim by default", func(t *testing.T) {
+		rw1 := &WriteRequest{Timeseries: []PreallocTimeseries{{TimeSeries: &TimeSeries{
+			Labels:     []LabelAdapter{{Name: "__name__", Value: "foo"}},
+			Histograms: []Histogram{floatHistogramFixture(), intHistogramFixture()},
+		}}}}
+
+		rw2, err := FromWriteRequestToRW2Request(rw1, nil, 0, ConvertOptions{})
+		require.NoError(t, err)
+		require.Len(t, rw2.TimeseriesRW2, 1)
+		require.Equal(t, []Histogram{floatHistogramFixture(), intHistogramFixture()}, rw2.TimeseriesRW2[0].Histograms)
+	})
+
+	t.Run("SkipHistograms drops all histograms", func(t *testing.T) {
+		rw1 := &WriteRequest{Timeseries: []PreallocTimeseries{{TimeSeries: &TimeSeries{
+			Labels:     []LabelAdapter{{Name: "__name__", Value: "foo"}},
+			Histograms: []Histogram{floatHistogramFixture()},
+		}}}}
+
+		rw2, err := FromWriteRequestToRW2Request(rw1, nil, 0, ConvertOptions{SkipHistograms: true})
+		require.NoError(t, err)
+		require.Empty(t, rw2.TimeseriesRW2[0].Histograms)
+	})
+
+	t.Run("HistogramEncodingForceInteger downcasts float histograms and leaves integer ones untouched", func(t *testing.T) {
+		rw1 := &WriteRequest{Timeseries: []PreallocTimeseries{{TimeSeries: &TimeSeries{
+			Labels:     []LabelAdapter{{Name: "__name__", Value: "foo"}},
+			Histograms: []Histogram{floatHistogramFixture(), intHistogramFixture()},
+		}}}}
+
+		rw2, err := FromWriteRequestToRW2Request(rw1, nil, 0, ConvertOptions{HistogramEncoding: HistogramEncodingForceInteger})
+		require.NoError(t, err)
+
+		got := rw2.TimeseriesRW2[0].Histograms
+		require.Len(t, got, 2)
+		require.False(t, got[0].IsFloatHistogram())
+		require.Equal(t, uint64(12), got[0].GetCountInt())
+		require.Equal(t, uint64(2), got[0].GetZeroCountInt())
+		require.Equal(t, []int64{1, 2}, got[0].PositiveDeltas)
+		require.Equal(t, intHistogramFixture(), got[1])
+	})
+}
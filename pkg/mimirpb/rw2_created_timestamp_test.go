@@ -0,0 +1,151 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package mimirpb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromWriteRequestToRW2Request_SynthesizeCreatedTimestampSamples(t *testing.T) {
+	metadata := []*MetricMetadata{{MetricFamilyName: "foo", Type: MetricMetadata_COUNTER}}
+
+	newRequest := func(createdTimestamp int64, samples []Sample) *WriteRequest {
+		return &WriteRequest{
+			Timeseries: []PreallocTimeseries{{TimeSeries: &TimeSeries{
+				Labels:           []LabelAdapter{{Name: "__name__", Value: "foo"}},
+				Samples:          samples,
+				CreatedTimestamp: createdTimestamp,
+			}}},
+			Metadata: metadata,
+		}
+	}
+
+	t.Run("CT == 0 is a no-op", func(t *testing.T) {
+		rw1 := newRequest(0, []Sample{{Value: 1, TimestampMs: 1000}})
+		rw2, err := FromWriteRequestToRW2Request(rw1, nil, 0, ConvertOptions{SynthesizeCreatedTimestampSamples: true})
+		require.NoError(t, err)
+		require.Equal(t, []Sample{{Value: 1, TimestampMs: 1000}}, rw2.TimeseriesRW2[0].Samples)
+	})
+
+	t.Run("CT == first sample timestamp is a no-op", func(t *testing.T) {
+		rw1 := newRequest(1000, []Sample{{Value: 1, TimestampMs: 1000}})
+		rw2, err := FromWriteRequestToRW2Request(rw1, nil, 0, ConvertOptions{SynthesizeCreatedTimestampSamples: true})
+		require.NoError(t, err)
+		require.Equal(t, []Sample{{Value: 1, TimestampMs: 1000}}, rw2.TimeseriesRW2[0].Samples)
+	})
+
+	t.Run("CT < first sample timestamp synthesizes a leading zero sample", func(t *testing.T) {
+		rw1 := newRequest(500, []Sample{{Value: 1, TimestampMs: 1000}})
+		rw2, err := FromWriteRequestToRW2Request(rw1, nil, 0, ConvertOptions{SynthesizeCreatedTimestampSamples: true})
+		require.NoError(t, err)
+		require.Equal(t, []Sample{{Value: 0, TimestampMs: 500}, {Value: 1, TimestampMs: 1000}}, rw2.TimeseriesRW2[0].Samples)
+	})
+
+	t.Run("CT > first sample timestamp is skipped, the series is left untouched, and OnInvalidCreatedTimestamp fires", func(t *testing.T) {
+		rw1 := newRequest(1500, []Sample{{Value: 1, TimestampMs: 1000}})
+		var invalidMetricNames []string
+		rw2, err := FromWriteRequestToRW2Request(rw1, nil, 0, ConvertOptions{
+			SynthesizeCreatedTimestampSamples: true,
+			OnInvalidCreatedTimestamp:         func(metricName string) { invalidMetricNames = append(invalidMetricNames, metricName) },
+		})
+		require.NoError(t, err)
+		require.Equal(t, []Sample{{Value: 1, TimestampMs: 1000}}, rw2.TimeseriesRW2[0].Samples)
+		require.Equal(t, []string{"foo"}, invalidMetricNames)
+	})
+
+	t.Run("OnInvalidCreatedTimestamp is optional", func(t *testing.T) {
+		rw1 := newRequest(1500, []Sample{{Value: 1, TimestampMs: 1000}})
+		require.NotPanics(t, func() {
+			_, err := FromWriteRequestToRW2Request(rw1, nil, 0, ConvertOptions{SynthesizeCreatedTimestampSamples: true})
+			require.NoError(t, err)
+		})
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		rw1 := newRequest(500, []Sample{{Value: 1, TimestampMs: 1000}})
+		rw2, err := FromWriteRequestToRW2Request(rw1, nil, 0, ConvertOptions{})
+		require.NoError(t, err)
+		require.Equal(t, []Sample{{Value: 1, TimestampMs: 1000}}, rw2.TimeseriesRW2[0].Samples)
+	})
+
+	t.Run("not synthesized for gauges", func(t *testing.T) {
+		rw1 := newRequest(500, []Sample{{Value: 1, TimestampMs: 1000}})
+		rw1.Metadata = []*MetricMetadata{{MetricFamilyName: "foo", Type: MetricMetadata_GAUGE}}
+		rw2, err := FromWriteRequestToRW2Request(rw1, nil, 0, ConvertOptions{SynthesizeCreatedTimestampSamples: true})
+		require.NoError(t, err)
+		require.Equal(t, []Sample{{Value: 1, TimestampMs: 1000}}, rw2.TimeseriesRW2[0].Samples)
+	})
+
+	t.Run("classic histogram/summary child series are matched to their family via the _sum/_count/_bucket suffix", func(t *testing.T) {
+		rw1 := &WriteRequest{
+			Timeseries: []PreallocTimeseries{
+				{TimeSeries: &TimeSeries{
+					Labels:           []LabelAdapter{{Name: "__name__", Value: "foo_sum"}},
+					Samples:          []Sample{{Value: 1, TimestampMs: 1000}},
+					CreatedTimestamp: 500,
+				}},
+				{TimeSeries: &TimeSeries{
+					Labels:           []LabelAdapter{{Name: "__name__", Value: "foo_count"}},
+					Samples:          []Sample{{Value: 1, TimestampMs: 1000}},
+					CreatedTimestamp: 500,
+				}},
+				{TimeSeries: &TimeSeries{
+					Labels:           []LabelAdapter{{Name: "__name__", Value: "foo_bucket"}, {Name: "le", Value: "+Inf"}},
+					Samples:          []Sample{{Value: 1, TimestampMs: 1000}},
+					CreatedTimestamp: 500,
+				}},
+			},
+			Metadata: []*MetricMetadata{{MetricFamilyName: "foo", Type: MetricMetadata_HISTOGRAM}},
+		}
+
+		rw2, err := FromWriteRequestToRW2Request(rw1, nil, 0, ConvertOptions{SynthesizeCreatedTimestampSamples: true})
+		require.NoError(t, err)
+
+		for i := range rw1.Timeseries {
+			require.Equal(t, []Sample{{Value: 0, TimestampMs: 500}, {Value: 1, TimestampMs: 1000}}, rw2.TimeseriesRW2[i].Samples)
+		}
+	})
+
+	t.Run("native histograms get an empty leading histogram instead of a zero sample", func(t *testing.T) {
+		rw1 := &WriteRequest{
+			Timeseries: []PreallocTimeseries{{TimeSeries: &TimeSeries{
+				Labels:           []LabelAdapter{{Name: "__name__", Value: "foo"}},
+				Histograms:       []Histogram{intHistogramFixture()},
+				CreatedTimestamp: 500,
+			}}},
+			Metadata: []*MetricMetadata{{MetricFamilyName: "foo", Type: MetricMetadata_HISTOGRAM}},
+		}
+
+		rw2, err := FromWriteRequestToRW2Request(rw1, nil, 0, ConvertOptions{SynthesizeCreatedTimestampSamples: true})
+		require.NoError(t, err)
+
+		got := rw2.TimeseriesRW2[0].Histograms
+		require.Len(t, got, 2)
+		require.Equal(t, int64(500), got[0].Timestamp)
+		require.Equal(t, uint64(0), got[0].GetCountInt())
+		require.Equal(t, intHistogramFixture(), got[1])
+	})
+
+	t.Run("float native histograms get a float-encoded empty leading histogram", func(t *testing.T) {
+		rw1 := &WriteRequest{
+			Timeseries: []PreallocTimeseries{{TimeSeries: &TimeSeries{
+				Labels:           []LabelAdapter{{Name: "__name__", Value: "foo"}},
+				Histograms:       []Histogram{floatHistogramFixture()},
+				CreatedTimestamp: 500,
+			}}},
+			Metadata: []*MetricMetadata{{MetricFamilyName: "foo", Type: MetricMetadata_HISTOGRAM}},
+		}
+
+		rw2, err := FromWriteRequestToRW2Request(rw1, nil, 0, ConvertOptions{SynthesizeCreatedTimestampSamples: true})
+		require.NoError(t, err)
+
+		got := rw2.TimeseriesRW2[0].Histograms
+		require.Len(t, got, 2)
+		require.Equal(t, int64(500), got[0].Timestamp)
+		require.True(t, got[0].IsFloatHistogram())
+		require.Equal(t, float64(0), got[0].GetCountFloat())
+		require.Equal(t, floatHistogramFixture(), got[1])
+	})
+}
@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package mimirpb
+
+import "fmt"
+
+// RW2StreamConverter converts a RW1 WriteRequest into a sequence of RW2 WriteRequests in bounded-memory
+// chunks, rather than materializing one O(all-series) RW2 request up front. Each chunk carries at most
+// maxSeriesPerChunk timeseries and its own self-consistent SymbolsRW2 table, built with the same
+// commonSymbols/offset as every other chunk so that the common-symbols contract still holds per chunk.
+//
+// This is intended for sharding very large write requests, e.g. splitting a single scrape payload across
+// several ingester requests without ever holding the whole converted request in memory at once.
+type RW2StreamConverter struct {
+	rw1               *WriteRequest
+	commonSymbols     *CommonSymbols
+	offset            uint32
+	maxSeriesPerChunk int
+	opts              ConvertOptions
+
+	seriesIdx   int
+	metadataIdx int
+	done        bool
+}
+
+// NewRW2StreamConverter returns an iterator that converts rw1 into a sequence of RW2 WriteRequests, each
+// containing at most maxSeriesPerChunk timeseries. Call Next repeatedly until it returns (nil, nil).
+// maxSeriesPerChunk is clamped to at least 1, so that Next always makes progress.
+func NewRW2StreamConverter(rw1 *WriteRequest, commonSymbols *CommonSymbols, offset uint32, maxSeriesPerChunk int, opts ConvertOptions) *RW2StreamConverter {
+	if maxSeriesPerChunk < 1 {
+		maxSeriesPerChunk = 1
+	}
+	return &RW2StreamConverter{
+		rw1:               rw1,
+		commonSymbols:     commonSymbols,
+		offset:            offset,
+		maxSeriesPerChunk: maxSeriesPerChunk,
+		opts:              opts,
+	}
+}
+
+// Next returns the next chunk of the conversion, or (nil, nil) once rw1 has been fully consumed.
+// Like FromWriteRequestToRW2Request, it leaves rw1 alone and may retain references into it, so rw1
+// must not be freed until every chunk it produced is no longer in use.
+func (c *RW2StreamConverter) Next() (*WriteRequest, error) {
+	if c.done {
+		return nil, nil
+	}
+	if c.rw1 == nil {
+		c.done = true
+		return nil, nil
+	}
+	if len(c.rw1.SymbolsRW2) > 0 || len(c.rw1.TimeseriesRW2) > 0 {
+		return nil, fmt.Errorf("the provided request is already rw2")
+	}
+	if c.seriesIdx >= len(c.rw1.Timeseries) && c.metadataIdx >= len(c.rw1.Metadata) {
+		c.done = true
+		return nil, nil
+	}
+
+	symbols := symbolsTableFromPool()
+	defer reuseSymbolsTable(symbols)
+	symbols.ConfigureCommonSymbols(c.offset, c.commonSymbols)
+
+	var metadataTypes map[string]MetricMetadata_MetricType
+	if c.opts.SynthesizeCreatedTimestampSamples {
+		metadataTypes = metadataTypeByMetricName(c.rw1.Metadata)
+	}
+
+	chunk := timeSeriesRW2SliceFromPool()
+	remaining := c.maxSeriesPerChunk
+	if cap(chunk) < remaining {
+		chunk = make([]TimeSeriesRW2, 0, remaining)
+	}
+
+	for ; c.seriesIdx < len(c.rw1.Timeseries) && remaining > 0; c.seriesIdx++ {
+		ts := c.rw1.Timeseries[c.seriesIdx]
+
+		const stringsPerLabel = 2
+		expLabelsCount := len(ts.Labels) * stringsPerLabel
+		refs := labelsRefsSliceFromPool()
+		if cap(refs) < expLabelsCount {
+			refs = make([]uint32, 0, expLabelsCount)
+		}
+
+		for i := range ts.Labels {
+			refs = append(refs, symbols.Symbolize(ts.Labels[i].Name), symbols.Symbolize(ts.Labels[i].Value))
+		}
+
+		samples := ts.Samples
+		histograms := convertHistogramsRW2(ts.Histograms, c.opts)
+		if c.opts.SynthesizeCreatedTimestampSamples {
+			name := metricName(ts.Labels)
+			samples, histograms = synthesizeCreatedTimestampSample(ts.CreatedTimestamp, metadataTypes[metricFamilyName(name)], samples, histograms, name, c.opts.OnInvalidCreatedTimestamp)
+		}
+
+		chunk = append(chunk, TimeSeriesRW2{
+			LabelsRefs:       refs,
+			Samples:          samples,
+			Histograms:       histograms,
+			Exemplars:        FromExemplarsToExemplarsRW2(ts.Exemplars, symbols),
+			Metadata:         MetadataRW2{},
+			CreatedTimestamp: ts.CreatedTimestamp,
+		})
+		remaining--
+	}
+
+	for ; c.metadataIdx < len(c.rw1.Metadata) && remaining > 0; c.metadataIdx++ {
+		meta := c.rw1.Metadata[c.metadataIdx]
+		labelsRefs := []uint32{symbols.Symbolize("__name__"), symbols.Symbolize(meta.MetricFamilyName)}
+		chunk = append(chunk, TimeSeriesRW2{
+			LabelsRefs: labelsRefs,
+			Metadata:   FromMetricMetadataToMetadataRW2(meta, symbols),
+		})
+		remaining--
+	}
+
+	rw2 := &WriteRequest{
+		Source:                    c.rw1.Source,
+		SkipLabelValidation:       c.rw1.SkipLabelValidation,
+		SkipLabelCountValidation:  c.rw1.SkipLabelCountValidation,
+		skipUnmarshalingExemplars: c.rw1.skipUnmarshalingExemplars,
+		TimeseriesRW2:             chunk,
+	}
+	syms := symbolsSliceFromPool()
+	rw2.SymbolsRW2 = symbols.SymbolsPrealloc(syms)
+
+	return rw2, nil
+}
@@ -0,0 +1,219 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package mimirpb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func seriesFixture(name string, ts int64) PreallocTimeseries {
+	return PreallocTimeseries{TimeSeries: &TimeSeries{
+		Labels:  []LabelAdapter{{Name: "__name__", Value: name}},
+		Samples: []Sample{{Value: 1, TimestampMs: ts}},
+	}}
+}
+
+// decodeLabelsRW2 resolves a RW2 LabelsRefs slice against the SymbolsRW2 table it was built with,
+// pairing up (name ref, value ref) the same way the real RW2 wire format does.
+func decodeLabelsRW2(symbols []string, refs []uint32) []LabelAdapter {
+	labels := make([]LabelAdapter, 0, len(refs)/2)
+	for i := 0; i < len(refs); i += 2 {
+		labels = append(labels, LabelAdapter{Name: symbols[refs[i]], Value: symbols[refs[i+1]]})
+	}
+	return labels
+}
+
+func drainRW2StreamConverter(t *testing.T, c *RW2StreamConverter) []*WriteRequest {
+	t.Helper()
+
+	var chunks []*WriteRequest
+	for {
+		chunk, err := c.Next()
+		require.NoError(t, err)
+		if chunk == nil {
+			return chunks
+		}
+		chunks = append(chunks, chunk)
+	}
+}
+
+func TestRW2StreamConverter_ChunkCounts(t *testing.T) {
+	t.Run("exact multiple of chunk size", func(t *testing.T) {
+		rw1 := &WriteRequest{Timeseries: []PreallocTimeseries{
+			seriesFixture("foo", 1), seriesFixture("bar", 1), seriesFixture("baz", 1), seriesFixture("qux", 1),
+		}}
+
+		chunks := drainRW2StreamConverter(t, NewRW2StreamConverter(rw1, nil, 0, 2, ConvertOptions{}))
+		require.Len(t, chunks, 2)
+		require.Len(t, chunks[0].TimeseriesRW2, 2)
+		require.Len(t, chunks[1].TimeseriesRW2, 2)
+	})
+
+	t.Run("remainder chunk", func(t *testing.T) {
+		rw1 := &WriteRequest{Timeseries: []PreallocTimeseries{
+			seriesFixture("foo", 1), seriesFixture("bar", 1), seriesFixture("baz", 1), seriesFixture("qux", 1), seriesFixture("quux", 1),
+		}}
+
+		chunks := drainRW2StreamConverter(t, NewRW2StreamConverter(rw1, nil, 0, 2, ConvertOptions{}))
+		require.Len(t, chunks, 3)
+		require.Len(t, chunks[0].TimeseriesRW2, 2)
+		require.Len(t, chunks[1].TimeseriesRW2, 2)
+		require.Len(t, chunks[2].TimeseriesRW2, 1)
+	})
+
+	t.Run("trailing metadata-only chunk", func(t *testing.T) {
+		rw1 := &WriteRequest{
+			Timeseries: []PreallocTimeseries{seriesFixture("foo", 1), seriesFixture("bar", 1)},
+			Metadata:   []*MetricMetadata{{MetricFamilyName: "foo", Type: MetricMetadata_COUNTER}},
+		}
+
+		chunks := drainRW2StreamConverter(t, NewRW2StreamConverter(rw1, nil, 0, 2, ConvertOptions{}))
+		require.Len(t, chunks, 2)
+		require.Len(t, chunks[0].TimeseriesRW2, 2)
+		require.Len(t, chunks[1].TimeseriesRW2, 1)
+		require.Equal(t, MetadataRW2_MetricType(MetricMetadata_COUNTER), chunks[1].TimeseriesRW2[0].Metadata.Type)
+	})
+
+	t.Run("metadata interleaves across a chunk boundary", func(t *testing.T) {
+		rw1 := &WriteRequest{
+			Timeseries: []PreallocTimeseries{seriesFixture("foo", 1)},
+			Metadata: []*MetricMetadata{
+				{MetricFamilyName: "foo", Type: MetricMetadata_COUNTER},
+				{MetricFamilyName: "bar", Type: MetricMetadata_GAUGE},
+			},
+		}
+
+		chunks := drainRW2StreamConverter(t, NewRW2StreamConverter(rw1, nil, 0, 2, ConvertOptions{}))
+		require.Len(t, chunks, 2)
+		require.Len(t, chunks[0].TimeseriesRW2, 2)
+		require.Len(t, chunks[1].TimeseriesRW2, 1)
+	})
+
+	t.Run("empty request produces no chunks", func(t *testing.T) {
+		chunks := drainRW2StreamConverter(t, NewRW2StreamConverter(&WriteRequest{}, nil, 0, 2, ConvertOptions{}))
+		require.Empty(t, chunks)
+	})
+
+	t.Run("maxSeriesPerChunk is clamped to at least 1", func(t *testing.T) {
+		rw1 := &WriteRequest{Timeseries: []PreallocTimeseries{seriesFixture("foo", 1), seriesFixture("bar", 1)}}
+
+		chunks := drainRW2StreamConverter(t, NewRW2StreamConverter(rw1, nil, 0, 0, ConvertOptions{}))
+		require.Len(t, chunks, 2)
+		require.Len(t, chunks[0].TimeseriesRW2, 1)
+		require.Len(t, chunks[1].TimeseriesRW2, 1)
+	})
+
+	t.Run("already-rw2 request is rejected", func(t *testing.T) {
+		rw1 := &WriteRequest{TimeseriesRW2: []TimeSeriesRW2{{}}}
+		c := NewRW2StreamConverter(rw1, nil, 0, 2, ConvertOptions{})
+		chunk, err := c.Next()
+		require.Error(t, err)
+		require.Nil(t, chunk)
+	})
+}
+
+func TestRW2StreamConverter_SymbolsHonorCommonSymbolsAndOffset(t *testing.T) {
+	commonSymbols := &CommonSymbols{}
+	const offset = 3
+
+	newRW1 := func() *WriteRequest {
+		return &WriteRequest{Timeseries: []PreallocTimeseries{
+			{TimeSeries: &TimeSeries{
+				Labels:  []LabelAdapter{{Name: "__name__", Value: "foo"}, {Name: "job", Value: "test"}},
+				Samples: []Sample{{Value: 1, TimestampMs: 1}},
+			}},
+			{TimeSeries: &TimeSeries{
+				Labels:  []LabelAdapter{{Name: "__name__", Value: "bar"}, {Name: "job", Value: "test"}},
+				Samples: []Sample{{Value: 1, TimestampMs: 1}},
+			}},
+			{TimeSeries: &TimeSeries{
+				Labels:  []LabelAdapter{{Name: "__name__", Value: "baz"}, {Name: "job", Value: "test"}},
+				Samples: []Sample{{Value: 1, TimestampMs: 1}},
+			}},
+		}}
+	}
+
+	chunks := drainRW2StreamConverter(t, NewRW2StreamConverter(newRW1(), commonSymbols, offset, 1, ConvertOptions{}))
+	require.Len(t, chunks, 3)
+
+	oneShot, err := FromWriteRequestToRW2Request(newRW1(), commonSymbols, offset, ConvertOptions{})
+	require.NoError(t, err)
+	require.Len(t, oneShot.TimeseriesRW2, 3)
+
+	for i, chunk := range chunks {
+		require.NotEmptyf(t, chunk.SymbolsRW2, "chunk %d must carry its own symbol table", i)
+		require.Len(t, chunk.TimeseriesRW2, 1)
+
+		refs := chunk.TimeseriesRW2[0].LabelsRefs
+		for _, ref := range refs {
+			require.GreaterOrEqualf(t, ref, uint32(offset), "chunk %d: symbol ref %d should land past the reserved common-symbols offset", i, ref)
+		}
+
+		gotLabels := decodeLabelsRW2(chunk.SymbolsRW2, refs)
+		wantLabels := decodeLabelsRW2(oneShot.SymbolsRW2, oneShot.TimeseriesRW2[i].LabelsRefs)
+		require.Equalf(t, wantLabels, gotLabels, "chunk %d should decode to the same labels as the single-shot conversion", i)
+	}
+}
+
+func TestRW2StreamConverter_OptionsThreadedThroughNext(t *testing.T) {
+	t.Run("SynthesizeCreatedTimestampSamples", func(t *testing.T) {
+		rw1 := &WriteRequest{
+			Timeseries: []PreallocTimeseries{{TimeSeries: &TimeSeries{
+				Labels:           []LabelAdapter{{Name: "__name__", Value: "foo"}},
+				Samples:          []Sample{{Value: 1, TimestampMs: 1000}},
+				CreatedTimestamp: 500,
+			}}},
+			Metadata: []*MetricMetadata{{MetricFamilyName: "foo", Type: MetricMetadata_COUNTER}},
+		}
+
+		chunks := drainRW2StreamConverter(t, NewRW2StreamConverter(rw1, nil, 0, 10, ConvertOptions{SynthesizeCreatedTimestampSamples: true}))
+		require.Len(t, chunks, 1)
+		require.Equal(t, []Sample{{Value: 0, TimestampMs: 500}, {Value: 1, TimestampMs: 1000}}, chunks[0].TimeseriesRW2[0].Samples)
+	})
+
+	t.Run("OnInvalidCreatedTimestamp fires for a CT newer than the first sample", func(t *testing.T) {
+		rw1 := &WriteRequest{
+			Timeseries: []PreallocTimeseries{{TimeSeries: &TimeSeries{
+				Labels:           []LabelAdapter{{Name: "__name__", Value: "foo"}},
+				Samples:          []Sample{{Value: 1, TimestampMs: 1000}},
+				CreatedTimestamp: 1500,
+			}}},
+			Metadata: []*MetricMetadata{{MetricFamilyName: "foo", Type: MetricMetadata_COUNTER}},
+		}
+
+		var invalidMetricNames []string
+		chunks := drainRW2StreamConverter(t, NewRW2StreamConverter(rw1, nil, 0, 10, ConvertOptions{
+			SynthesizeCreatedTimestampSamples: true,
+			OnInvalidCreatedTimestamp:         func(metricName string) { invalidMetricNames = append(invalidMetricNames, metricName) },
+		}))
+		require.Len(t, chunks, 1)
+		require.Equal(t, []Sample{{Value: 1, TimestampMs: 1000}}, chunks[0].TimeseriesRW2[0].Samples)
+		require.Equal(t, []string{"foo"}, invalidMetricNames)
+	})
+
+	t.Run("HistogramEncodingForceInteger", func(t *testing.T) {
+		rw1 := &WriteRequest{Timeseries: []PreallocTimeseries{{TimeSeries: &TimeSeries{
+			Labels:     []LabelAdapter{{Name: "__name__", Value: "foo"}},
+			Histograms: []Histogram{floatHistogramFixture()},
+		}}}}
+
+		chunks := drainRW2StreamConverter(t, NewRW2StreamConverter(rw1, nil, 0, 10, ConvertOptions{HistogramEncoding: HistogramEncodingForceInteger}))
+		require.Len(t, chunks, 1)
+		got := chunks[0].TimeseriesRW2[0].Histograms
+		require.Len(t, got, 1)
+		require.False(t, got[0].IsFloatHistogram())
+	})
+
+	t.Run("SkipHistograms", func(t *testing.T) {
+		rw1 := &WriteRequest{Timeseries: []PreallocTimeseries{{TimeSeries: &TimeSeries{
+			Labels:     []LabelAdapter{{Name: "__name__", Value: "foo"}},
+			Histograms: []Histogram{floatHistogramFixture()},
+		}}}}
+
+		chunks := drainRW2StreamConverter(t, NewRW2StreamConverter(rw1, nil, 0, 10, ConvertOptions{SkipHistograms: true}))
+		require.Len(t, chunks, 1)
+		require.Empty(t, chunks[0].TimeseriesRW2[0].Histograms)
+	})
+}
@@ -0,0 +1,188 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package ast
+
+import (
+	"context"
+	"math"
+
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// ConstantFolder evaluates sub-expressions whose operands are all constant (a NumberLiteral, or
+// vector(NumberLiteral)) at optimization time, so the query engine doesn't re-evaluate otherwise-constant
+// arithmetic on every step.
+//
+// Rules applied: NumberLiteral op NumberLiteral -> NumberLiteral; vector(NumberLiteral) op
+// vector(NumberLiteral) -> vector(NumberLiteral); unary minus on NumberLiteral; scalar(vector(x)) ->
+// NumberLiteral(x). Binary folding covers the arithmetic operators (+, -, *, /, %, ^) only; comparison and
+// set operators are left as they are. NaN/Inf operands are left untouched, and so is an operation whose
+// result would be NaN/Inf (e.g. `1 / 0`), since there's no constant-folding benefit to evaluating them and
+// doing so risks changing how the expression renders.
+type ConstantFolder struct {
+	mapper *constantFolderMapper
+}
+
+// NewConstantFolder returns an optimization pass that folds constant arithmetic in a parsed PromQL
+// expression tree.
+func NewConstantFolder() *ConstantFolder {
+	return &ConstantFolder{mapper: &constantFolderMapper{}}
+}
+
+func (o *ConstantFolder) Apply(_ context.Context, expr parser.Expr) (parser.Expr, error) {
+	return o.mapper.fold(expr), nil
+}
+
+type constantFolderMapper struct {
+	changed bool
+}
+
+func (m *constantFolderMapper) HasChanged() bool {
+	return m.changed
+}
+
+// fold recursively folds expr's children before trying to fold expr itself, so that e.g. `(2*3)` inside a
+// larger expression is reduced before its parent is considered.
+func (m *constantFolderMapper) fold(expr parser.Expr) parser.Expr {
+	switch e := expr.(type) {
+	case *parser.ParenExpr:
+		before := e.Expr
+		e.Expr = m.fold(e.Expr)
+		if e.Expr != before {
+			// The child was actually folded to a constant: unwrap the now-redundant parens around it,
+			// e.g. `(2*3)` -> `6`, not `(6)`.
+			switch e.Expr.(type) {
+			case *parser.NumberLiteral, *parser.Call:
+				return e.Expr
+			}
+		}
+		return e
+	case *parser.UnaryExpr:
+		e.Expr = m.fold(e.Expr)
+		if e.Op == parser.SUB {
+			if n, ok := asFoldableNumber(e.Expr); ok {
+				m.changed = true
+				return &parser.NumberLiteral{Val: -n, PosRange: e.PositionRange()}
+			}
+		}
+		return e
+	case *parser.BinaryExpr:
+		e.LHS = m.fold(e.LHS)
+		e.RHS = m.fold(e.RHS)
+		return m.foldBinary(e)
+	case *parser.Call:
+		for i := range e.Args {
+			e.Args[i] = m.fold(e.Args[i])
+		}
+		return m.foldCall(e)
+	case *parser.AggregateExpr:
+		e.Expr = m.fold(e.Expr)
+		if e.Param != nil {
+			e.Param = m.fold(e.Param)
+		}
+		return e
+	case *parser.SubqueryExpr:
+		e.Expr = m.fold(e.Expr)
+		return e
+	default:
+		return expr
+	}
+}
+
+func (m *constantFolderMapper) foldBinary(e *parser.BinaryExpr) parser.Expr {
+	lhs, lhsIsVector, lhsOK := constantFoldOperand(e.LHS)
+	if !lhsOK {
+		return e
+	}
+	rhs, rhsIsVector, rhsOK := constantFoldOperand(e.RHS)
+	if !rhsOK {
+		return e
+	}
+
+	result, ok := evalConstantBinaryOp(e.Op, lhs, rhs)
+	if !ok || math.IsNaN(result) || math.IsInf(result, 0) {
+		return e
+	}
+
+	m.changed = true
+	lit := &parser.NumberLiteral{Val: result, PosRange: e.PositionRange()}
+	if lhsIsVector || rhsIsVector {
+		return vectorCall(lit)
+	}
+	return lit
+}
+
+func (m *constantFolderMapper) foldCall(e *parser.Call) parser.Expr {
+	if e.Func == nil || e.Func.Name != "scalar" || len(e.Args) != 1 {
+		return e
+	}
+
+	inner, ok := e.Args[0].(*parser.Call)
+	if !ok || inner.Func == nil || inner.Func.Name != "vector" || len(inner.Args) != 1 {
+		return e
+	}
+
+	n, ok := asFoldableNumber(inner.Args[0])
+	if !ok {
+		return e
+	}
+
+	m.changed = true
+	return &parser.NumberLiteral{Val: n, PosRange: e.PositionRange()}
+}
+
+// constantFoldOperand reports whether expr is foldable as a constant operand, i.e. a NumberLiteral or a
+// vector(NumberLiteral) call, unwrapping any enclosing parentheses. isVector distinguishes the two cases, so
+// a BinaryExpr folding a vector operand can wrap its own result back up in vector(...).
+func constantFoldOperand(expr parser.Expr) (val float64, isVector bool, ok bool) {
+	if n, ok := asFoldableNumber(expr); ok {
+		return n, false, true
+	}
+
+	call, isCall := pushDownUnwrapParens(expr).(*parser.Call)
+	if !isCall || call.Func == nil || call.Func.Name != "vector" || len(call.Args) != 1 {
+		return 0, false, false
+	}
+
+	n, ok := asFoldableNumber(call.Args[0])
+	if !ok {
+		return 0, false, false
+	}
+	return n, true, true
+}
+
+// asFoldableNumber reports whether expr is a NumberLiteral suitable for folding: NaN/Inf are excluded, since
+// folding them risks changing how surrounding expressions render without any evaluation benefit.
+func asFoldableNumber(expr parser.Expr) (float64, bool) {
+	n, ok := pushDownUnwrapParens(expr).(*parser.NumberLiteral)
+	if !ok || math.IsNaN(n.Val) || math.IsInf(n.Val, 0) {
+		return 0, false
+	}
+	return n.Val, true
+}
+
+func evalConstantBinaryOp(op parser.ItemType, lhs, rhs float64) (float64, bool) {
+	switch op {
+	case parser.ADD:
+		return lhs + rhs, true
+	case parser.SUB:
+		return lhs - rhs, true
+	case parser.MUL:
+		return lhs * rhs, true
+	case parser.DIV:
+		return lhs / rhs, true
+	case parser.MOD:
+		return math.Mod(lhs, rhs), true
+	case parser.POW:
+		return math.Pow(lhs, rhs), true
+	default:
+		return 0, false
+	}
+}
+
+func vectorCall(lit *parser.NumberLiteral) *parser.Call {
+	return &parser.Call{
+		Func: parser.Functions["vector"],
+		Args: parser.Expressions{lit},
+	}
+}
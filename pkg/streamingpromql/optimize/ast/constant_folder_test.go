@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package ast
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/stretchr/testify/require"
+)
+
+var testCasesConstantFolder = map[string]string{
+	`2*3`:                        `6`,
+	`3 + vector(4)`:              `vector(7)`,
+	`vector(4) + 3`:              `vector(7)`,
+	`vector(3) + vector(4)`:      `vector(7)`,
+	`-(3)`:                       `-3`,
+	`scalar(vector(4))`:          `4`,
+	`foo + (2*3)`:                `foo + 6`,
+	`foo + bar`:                  `foo + bar`,
+	`NaN + 1`:                    `NaN + 1`,
+	`1 / 0`:                      `1 / 0`,
+	`sum(vector(2) * vector(3))`: `sum(vector(6))`,
+}
+
+func TestConstantFolder(t *testing.T) {
+	ctx := context.Background()
+
+	for input, expected := range testCasesConstantFolder {
+		t.Run(input, func(t *testing.T) {
+			expectedExpr, err := parser.ParseExpr(expected)
+			require.NoError(t, err)
+
+			inputExpr, err := parser.ParseExpr(input)
+			require.NoError(t, err)
+			optimizer := NewConstantFolder()
+			outputExpr, err := optimizer.Apply(ctx, inputExpr)
+			require.NoError(t, err)
+
+			require.Equal(t, expectedExpr.String(), outputExpr.String())
+			require.Equal(t, input != expected, optimizer.mapper.HasChanged())
+		})
+	}
+}
+
+func TestConstantFolderWithData(t *testing.T) {
+	testASTOptimizationPassWithData(t, `
+		load 1m
+			foo	1+1x<num samples>
+	`, testCasesConstantFolder)
+}
+
+// TestConstantFolderComposesWithReorderHistogramAggregation checks that running ConstantFolder after
+// ReorderHistogramAggregation folds the constant part of an expression while leaving the other pass's
+// rewrite of the histogram aggregation untouched.
+func TestConstantFolderComposesWithReorderHistogramAggregation(t *testing.T) {
+	ctx := context.Background()
+
+	inputExpr, err := parser.ParseExpr(`histogram_sum(sum(foo)) + (2*3)`)
+	require.NoError(t, err)
+
+	reordered, err := NewReorderHistogramAggregation().Apply(ctx, inputExpr)
+	require.NoError(t, err)
+
+	folded, err := NewConstantFolder().Apply(ctx, reordered)
+	require.NoError(t, err)
+
+	expectedExpr, err := parser.ParseExpr(`sum(histogram_sum(foo)) + 6`)
+	require.NoError(t, err)
+	require.Equal(t, expectedExpr.String(), folded.String())
+}
@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package ast
+
+import (
+	"context"
+
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// PushDownHistogramQuantile rewrites histogram_quantile(q, sum by (le, ...) (rate(foo[5m]))) into
+// histogram_quantile(q, sum by (...) (rate(foo[5m]))) when the rate/increase operand has no `le` matcher,
+// i.e. it targets native histograms rather than classic ones. The same rewrite applies to
+// histogram_fraction(lower, upper, sum by (le, ...) (rate(foo[5m]))), whose aggregate operand is its third
+// argument rather than its second. Grouping by `le` is a leftover from the classic-histogram idiom: native
+// histogram samples carry no `le` label at all, so keeping it in the grouping is both meaningless and
+// wasted grouping overhead.
+//
+// Whether a selector targets native or classic histograms can't be told apart structurally: the classic
+// idiom `sum by (le, job) (rate(foo_bucket[5m]))` never puts `le` in the selector either, it's obtained
+// purely via `by (le)`. Applying this rewrite to that pattern would merge every bucket together and
+// silently corrupt the result. This pass is therefore opt-in: it's a no-op unless the caller explicitly
+// asserts (via assumeNativeHistograms) that the series targeted by histogram_quantile in this query are
+// native histograms.
+type PushDownHistogramQuantile struct {
+	assumeNativeHistograms bool
+	mapper                 *pushDownHistogramQuantileMapper
+}
+
+// NewPushDownHistogramQuantile returns an optimization pass that drops redundant `le` grouping from
+// histogram_quantile's sum-by-rate operand when it wraps native histograms. The pass only runs when
+// assumeNativeHistograms is true: the caller must have independent knowledge (e.g. from series metadata
+// or a per-tenant configuration flag) that the targeted series are native histograms, since this cannot
+// be determined from the query AST alone.
+func NewPushDownHistogramQuantile(assumeNativeHistograms bool) *PushDownHistogramQuantile {
+	return &PushDownHistogramQuantile{assumeNativeHistograms: assumeNativeHistograms, mapper: &pushDownHistogramQuantileMapper{}}
+}
+
+func (o *PushDownHistogramQuantile) Apply(_ context.Context, expr parser.Expr) (parser.Expr, error) {
+	if !o.assumeNativeHistograms {
+		return expr, nil
+	}
+	if err := parser.Walk(o.mapper, expr, nil); err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+type pushDownHistogramQuantileMapper struct {
+	changed bool
+}
+
+func (m *pushDownHistogramQuantileMapper) HasChanged() bool {
+	return m.changed
+}
+
+func (m *pushDownHistogramQuantileMapper) Visit(node parser.Node, _ []parser.Node) (parser.Visitor, error) {
+	call, ok := node.(*parser.Call)
+	if !ok || call.Func == nil {
+		return m, nil
+	}
+
+	var aggArg parser.Expr
+	switch {
+	case call.Func.Name == "histogram_quantile" && len(call.Args) == 2:
+		aggArg = call.Args[1]
+	case call.Func.Name == "histogram_fraction" && len(call.Args) == 3:
+		aggArg = call.Args[2]
+	default:
+		return m, nil
+	}
+
+	agg, ok := pushDownUnwrapParens(aggArg).(*parser.AggregateExpr)
+	if !ok || agg.Op != parser.SUM || agg.Without || !pushDownContainsGrouping(agg.Grouping, "le") {
+		return m, nil
+	}
+
+	if !pushDownIsRateOrIncreaseOverNativeHistogramSelector(agg.Expr) {
+		return m, nil
+	}
+
+	agg.Grouping = pushDownRemoveGrouping(agg.Grouping, "le")
+	m.changed = true
+	return m, nil
+}
+
+func pushDownUnwrapParens(expr parser.Expr) parser.Expr {
+	for {
+		p, ok := expr.(*parser.ParenExpr)
+		if !ok {
+			return expr
+		}
+		expr = p.Expr
+	}
+}
+
+func pushDownContainsGrouping(grouping []string, name string) bool {
+	for _, g := range grouping {
+		if g == name {
+			return true
+		}
+	}
+	return false
+}
+
+func pushDownRemoveGrouping(grouping []string, name string) []string {
+	out := make([]string, 0, len(grouping))
+	for _, g := range grouping {
+		if g != name {
+			out = append(out, g)
+		}
+	}
+	return out
+}
+
+// pushDownIsRateOrIncreaseOverNativeHistogramSelector reports whether expr is a rate()/increase() call over a
+// selector with no explicit `le` matcher. This is a necessary but not sufficient condition for the selector
+// to target native histograms (see the caveat on PushDownHistogramQuantile), which is why callers must also
+// gate on assumeNativeHistograms.
+func pushDownIsRateOrIncreaseOverNativeHistogramSelector(expr parser.Expr) bool {
+	call, ok := pushDownUnwrapParens(expr).(*parser.Call)
+	if !ok || call.Func == nil || len(call.Args) != 1 {
+		return false
+	}
+	if call.Func.Name != "rate" && call.Func.Name != "increase" {
+		return false
+	}
+
+	matrix, ok := pushDownUnwrapParens(call.Args[0]).(*parser.MatrixSelector)
+	if !ok {
+		return false
+	}
+	vs, ok := matrix.VectorSelector.(*parser.VectorSelector)
+	if !ok {
+		return false
+	}
+	for _, m := range vs.LabelMatchers {
+		if m.Name == "le" {
+			return false
+		}
+	}
+	return true
+}
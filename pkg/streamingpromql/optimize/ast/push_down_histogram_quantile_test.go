@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package ast
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/stretchr/testify/require"
+)
+
+var testCasesPushDownHistogramQuantile = map[string]string{
+	`histogram_quantile(0.9, sum by (le, job) (rate(foo[5m])))`:     `histogram_quantile(0.9, sum by (job) (rate(foo[5m])))`,
+	`histogram_quantile(0.9, sum by (job) (rate(foo[5m])))`:         `histogram_quantile(0.9, sum by (job) (rate(foo[5m])))`,
+	`histogram_quantile(0.9, sum by (le) (rate(foo{le="1"}[5m])))`:  `histogram_quantile(0.9, sum by (le) (rate(foo{le="1"}[5m])))`,
+	`histogram_quantile(0.9, sum by (le) (foo))`:                    `histogram_quantile(0.9, sum by (le) (foo))`,
+	`histogram_quantile(0.9, sum without (le) (rate(foo[5m])))`:     `histogram_quantile(0.9, sum without (le) (rate(foo[5m])))`,
+	`(histogram_quantile(0.9, (sum by (le, job) (rate(foo[5m])))))`: `(histogram_quantile(0.9, (sum by (job) (rate(foo[5m])))))`,
+	`histogram_fraction(0, 0.2, sum by (le, job) (rate(foo[5m])))`:  `histogram_fraction(0, 0.2, sum by (job) (rate(foo[5m])))`,
+	`histogram_fraction(0, 0.2, sum by (job) (rate(foo[5m])))`:      `histogram_fraction(0, 0.2, sum by (job) (rate(foo[5m])))`,
+}
+
+func TestPushDownHistogramQuantile(t *testing.T) {
+	ctx := context.Background()
+
+	for input, expected := range testCasesPushDownHistogramQuantile {
+		t.Run(input, func(t *testing.T) {
+			expectedExpr, err := parser.ParseExpr(expected)
+			require.NoError(t, err)
+
+			inputExpr, err := parser.ParseExpr(input)
+			require.NoError(t, err)
+			optimizer := NewPushDownHistogramQuantile(true)
+			outputExpr, err := optimizer.Apply(ctx, inputExpr)
+			require.NoError(t, err)
+
+			require.Equal(t, expectedExpr.String(), outputExpr.String())
+			require.Equal(t, input != expected, optimizer.mapper.HasChanged())
+		})
+	}
+}
+
+func TestPushDownHistogramQuantileWithData(t *testing.T) {
+	testASTOptimizationPassWithData(t, `
+		load 1m
+			foo	{{schema:0 sum:4 count:4 buckets:[1 2 1]}}+{{sum:2 count:1 buckets:[1] offset:1}}x<num samples>
+	`, testCasesPushDownHistogramQuantile)
+}
+
+// TestPushDownHistogramQuantileNotAppliedWithoutOptIn verifies that the rewrite never runs unless the
+// caller explicitly asserts the targeted series are native histograms, since the classic-histogram idiom
+// `sum by (le, ...) (rate(foo_bucket[5m]))` is structurally indistinguishable from the native case this
+// pass is meant to simplify.
+func TestPushDownHistogramQuantileNotAppliedWithoutOptIn(t *testing.T) {
+	ctx := context.Background()
+
+	for input := range testCasesPushDownHistogramQuantile {
+		t.Run(input, func(t *testing.T) {
+			inputExpr, err := parser.ParseExpr(input)
+			require.NoError(t, err)
+
+			optimizer := NewPushDownHistogramQuantile(false)
+			outputExpr, err := optimizer.Apply(ctx, inputExpr)
+			require.NoError(t, err)
+
+			require.Equal(t, inputExpr.String(), outputExpr.String())
+			require.False(t, optimizer.mapper.HasChanged())
+		})
+	}
+}